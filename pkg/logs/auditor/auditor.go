@@ -6,10 +6,6 @@
 package auditor
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"path/filepath"
 	"sync"
 	"time"
 
@@ -32,27 +28,86 @@ type RegistryEntry struct {
 
 // An Auditor handles messages successfully submitted to the intake
 type Auditor struct {
-	inputChan    chan message.Message
-	registry     map[string]*RegistryEntry
-	registryPath string
-	mu           sync.Mutex
-	entryTTL     time.Duration
-	done         chan struct{}
+	inputChan     chan message.Message
+	registry      Registry
+	entryTTL      time.Duration
+	flushPeriod   time.Duration
+	cleanupPeriod time.Duration
+	done          chan struct{}
+
+	mu            sync.Mutex
+	dirty         map[string]struct{}
+	gcRequired    bool
+	lastFlushTime time.Time
+	lastFlushErr  error
+
+	subs *subscribers
+}
+
+// Status is a point-in-time snapshot of the auditor's health, surfaced by
+// the agent status command.
+type Status struct {
+	Entries       int
+	LastFlushTime time.Time
+	LastFlushErr  string
+}
+
+// Status returns a snapshot of the auditor's current health.
+func (a *Auditor) Status() Status {
+	a.mu.Lock()
+	lastFlushTime := a.lastFlushTime
+	lastFlushErr := ""
+	if a.lastFlushErr != nil {
+		lastFlushErr = a.lastFlushErr.Error()
+	}
+	a.mu.Unlock()
+	return Status{
+		Entries:       a.registrySize(),
+		LastFlushTime: lastFlushTime,
+		LastFlushErr:  lastFlushErr,
+	}
 }
 
-// New returns an initialized Auditor
-func New(inputChan chan message.Message, runPath string) *Auditor {
-	return &Auditor{
-		inputChan:    inputChan,
-		registryPath: filepath.Join(runPath, "registry.json"),
-		entryTTL:     defaultTTL,
-		done:         make(chan struct{}),
+// Option overrides a default setting of an Auditor created with New.
+type Option func(*Auditor)
+
+// WithFlushPeriod makes the Auditor flush the registry at the given period
+// instead of the default 1s, so tests can drive flushes deterministically.
+func WithFlushPeriod(period time.Duration) Option {
+	return func(a *Auditor) {
+		a.flushPeriod = period
 	}
 }
 
+// WithCleanupPeriod makes the Auditor evict expired registry entries at the
+// given period instead of the default 300s, so tests can drive cleanups
+// deterministically.
+func WithCleanupPeriod(period time.Duration) Option {
+	return func(a *Auditor) {
+		a.cleanupPeriod = period
+	}
+}
+
+// New returns an initialized Auditor that persists offsets to registry
+func New(inputChan chan message.Message, registry Registry, opts ...Option) *Auditor {
+	a := &Auditor{
+		inputChan:     inputChan,
+		registry:      registry,
+		entryTTL:      defaultTTL,
+		flushPeriod:   defaultFlushPeriod,
+		cleanupPeriod: defaultCleanupPeriod,
+		done:          make(chan struct{}),
+		dirty:         make(map[string]struct{}),
+		subs:          newSubscribers(),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
 // Start starts the Auditor
 func (a *Auditor) Start() {
-	a.registry = a.recoverRegistry()
 	a.cleanupRegistry()
 	go a.run()
 }
@@ -62,16 +117,18 @@ func (a *Auditor) Stop() {
 	close(a.inputChan)
 	<-a.done
 	a.cleanupRegistry()
-	err := a.flushRegistry()
-	if err != nil {
+	if err := a.Flush(); err != nil {
+		log.Warn(err)
+	}
+	if err := a.registry.Close(); err != nil {
 		log.Warn(err)
 	}
 }
 
 // run keeps up to date the registry depending on different events
 func (a *Auditor) run() {
-	cleanUpTicker := time.NewTicker(defaultCleanupPeriod)
-	flushTicker := time.NewTicker(defaultFlushPeriod)
+	cleanUpTicker := time.NewTicker(a.cleanupPeriod)
+	flushTicker := time.NewTicker(a.flushPeriod)
 	defer func() {
 		// clean the context
 		cleanUpTicker.Stop()
@@ -92,85 +149,119 @@ func (a *Auditor) run() {
 			// remove expired offsets from registry
 			a.cleanupRegistry()
 		case <-flushTicker.C:
-			// saves current registry into disk
-			err := a.flushRegistry()
-			if err != nil {
+			// nothing changed since the last flush, skip rewriting the registry
+			if !a.needsFlush() {
+				continue
+			}
+			if err := a.Flush(); err != nil {
 				log.Warn(err)
 			}
 		}
 	}
 }
 
-// recoverRegistry rebuilds the registry from the state file found at path
-func (a *Auditor) recoverRegistry() map[string]*RegistryEntry {
-	mr, err := ioutil.ReadFile(a.registryPath)
-	if err != nil {
-		log.Error(err)
-		return make(map[string]*RegistryEntry)
+// needsFlush reports whether anything has changed since the last Flush.
+func (a *Auditor) needsFlush() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.dirty) > 0 || a.gcRequired
+}
+
+// Flush persists the registry to its backend and clears the dirty state.
+// It is exposed so Stop and integration tests can force a synchronous,
+// durable write outside of the normal flush ticker.
+func (a *Auditor) Flush() error {
+	a.mu.Lock()
+	committed := make([]string, 0, len(a.dirty))
+	for identifier := range a.dirty {
+		committed = append(committed, identifier)
 	}
-	r, err := a.unmarshalRegistry(mr)
+	a.dirty = make(map[string]struct{})
+	a.gcRequired = false
+	a.mu.Unlock()
+
+	start := time.Now()
+	err := a.registry.Flush()
+	flushDuration.observe(time.Since(start))
+
+	a.mu.Lock()
+	a.lastFlushTime = time.Now()
+	a.lastFlushErr = err
+	a.mu.Unlock()
+
 	if err != nil {
-		log.Error(err)
-		return make(map[string]*RegistryEntry)
+		flushErrorsTotal.Add(1)
+		return err
 	}
-	return r
+
+	registryEntries.Set(int64(a.registrySize()))
+	// Subscribers only observe durable state: notify them now that the
+	// flush above has succeeded, not when updateRegistry first set the
+	// in-memory value.
+	committedEntries := make(map[string]RegistryEntry, len(committed))
+	for _, identifier := range committed {
+		if entry, exists := a.registry.Get(identifier); exists {
+			committedEntries[identifier] = entry
+			a.subs.notify(identifier, entry)
+		}
+	}
+	a.subs.notifyAll(committedEntries)
+	return nil
+}
+
+// registrySize returns the number of entries currently tracked by the
+// registry.
+func (a *Auditor) registrySize() int {
+	var n int
+	a.registry.Range(func(identifier string, entry RegistryEntry) { n++ })
+	return n
 }
 
 // cleanupRegistry removes expired entries from the registry
 func (a *Auditor) cleanupRegistry() {
-	a.mu.Lock()
-	defer a.mu.Unlock()
 	expireBefore := time.Now().UTC().Add(-a.entryTTL)
-	for path, entry := range a.registry {
+	var expired []string
+	a.registry.Range(func(identifier string, entry RegistryEntry) {
 		if entry.LastUpdated.Before(expireBefore) {
-			delete(a.registry, path)
+			expired = append(expired, identifier)
 		}
+	})
+	if len(expired) == 0 {
+		return
 	}
+	for _, identifier := range expired {
+		a.registry.Delete(identifier)
+	}
+	entriesEvicted.Add(int64(len(expired)))
+	a.mu.Lock()
+	a.gcRequired = true
+	a.mu.Unlock()
+	registryEntries.Set(int64(a.registrySize()))
 }
 
 // updateRegistry updates the registry entry matching identifier with new the offset and timestamp
 func (a *Auditor) updateRegistry(identifier string, offset int64, timestamp string) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
 	if identifier == "" {
 		// An empty Identifier means that we don't want to track down the offset
 		// This is useful for origins that don't have offsets (networks), or when we
 		// specially want to avoid storing the offset
 		return
 	}
-	a.registry[identifier] = &RegistryEntry{
+	a.registry.Set(identifier, RegistryEntry{
 		LastUpdated: time.Now().UTC(),
 		Offset:      offset,
 		Timestamp:   timestamp,
-	}
-}
-
-// readOnlyRegistryCopy returns a read only copy of the registry
-func (a *Auditor) readOnlyRegistryCopy() map[string]RegistryEntry {
+	})
 	a.mu.Lock()
-	defer a.mu.Unlock()
-	r := make(map[string]RegistryEntry)
-	for path, entry := range a.registry {
-		r[path] = *entry
-	}
-	return r
-}
-
-// flushRegistry writes on disk the registry at the given path
-func (a *Auditor) flushRegistry() error {
-	r := a.readOnlyRegistryCopy()
-	mr, err := a.marshalRegistry(r)
-	if err != nil {
-		return err
-	}
-	return ioutil.WriteFile(a.registryPath, mr, 0644)
+	a.dirty[identifier] = struct{}{}
+	a.mu.Unlock()
+	registryEntries.Set(int64(a.registrySize()))
 }
 
 // GetLastCommittedOffset returns the last committed offset for a given identifier,
 // returns 0 if it does not exist.
 func (a *Auditor) GetLastCommittedOffset(identifier string) int64 {
-	r := a.readOnlyRegistryCopy()
-	entry, exists := r[identifier]
+	entry, exists := a.registry.Get(identifier)
 	if !exists {
 		return 0
 	}
@@ -179,76 +270,9 @@ func (a *Auditor) GetLastCommittedOffset(identifier string) int64 {
 
 // GetLastCommittedTimestamp returns the last committed offset for a given identifier
 func (a *Auditor) GetLastCommittedTimestamp(identifier string) string {
-	r := a.readOnlyRegistryCopy()
-	entry, ok := r[identifier]
-	if !ok {
+	entry, exists := a.registry.Get(identifier)
+	if !exists {
 		return ""
 	}
 	return entry.Timestamp
 }
-
-// JSONRegistry represents the registry that will be written on disk
-type JSONRegistry struct {
-	Version  int
-	Registry map[string]RegistryEntry
-}
-
-// marshalRegistry marshals a registry
-func (a *Auditor) marshalRegistry(registry map[string]RegistryEntry) ([]byte, error) {
-	r := JSONRegistry{
-		Version:  1,
-		Registry: registry,
-	}
-	return json.Marshal(r)
-}
-
-// unmarshalRegistry unmarshals a registry
-func (a *Auditor) unmarshalRegistry(b []byte) (map[string]*RegistryEntry, error) {
-	var r JSONRegistry
-	err := json.Unmarshal(b, &r)
-	if err != nil {
-		return nil, err
-	}
-	registry := make(map[string]*RegistryEntry)
-	if r.Version == 1 {
-		for path, entry := range r.Registry {
-			newEntry := entry
-			registry[path] = &newEntry
-		}
-	} else if r.Version == 0 {
-		return a.unmarshalRegistryV0(b)
-	}
-	return registry, nil
-}
-
-// Legacy Registry logic
-
-type registryEntryV0 struct {
-	Path      string
-	Timestamp time.Time
-	Offset    int64
-}
-
-type jsonRegistryV0 struct {
-	Version  int
-	Registry map[string]registryEntryV0
-}
-
-func (a *Auditor) unmarshalRegistryV0(b []byte) (map[string]*RegistryEntry, error) {
-	var r jsonRegistryV0
-	err := json.Unmarshal(b, &r)
-	if err != nil {
-		return nil, err
-	}
-	registry := make(map[string]*RegistryEntry)
-	for path, entry := range r.Registry {
-		newEntry := RegistryEntry{}
-		newEntry.Offset = entry.Offset
-		newEntry.LastUpdated = entry.Timestamp
-		newEntry.Timestamp = ""
-		// from v0 to v1, we also prefixed path with file:
-		newPath := fmt.Sprintf("file:%s", path)
-		registry[newPath] = &newEntry
-	}
-	return registry, nil
-}
@@ -0,0 +1,144 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package auditor
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+// countingRegistry wraps a MemoryRegistry to count how many times Flush is
+// called, so tests can assert the dirty-set tracking actually skips
+// no-op flushes.
+type countingRegistry struct {
+	*MemoryRegistry
+	flushes int32
+}
+
+func (r *countingRegistry) Flush() error {
+	atomic.AddInt32(&r.flushes, 1)
+	return r.MemoryRegistry.Flush()
+}
+
+func newTestAuditor() (*Auditor, *MemoryRegistry) {
+	registry := NewMemoryRegistry()
+	return New(make(chan message.Message), registry), registry
+}
+
+func TestUpdateRegistry(t *testing.T) {
+	a, registry := newTestAuditor()
+
+	a.updateRegistry("file:/tmp/a.log", 42, "42")
+
+	entry, exists := registry.Get("file:/tmp/a.log")
+	if !exists {
+		t.Fatal("expected entry to exist")
+	}
+	if entry.Offset != 42 {
+		t.Fatalf("expected offset 42, got %d", entry.Offset)
+	}
+	if a.GetLastCommittedOffset("file:/tmp/a.log") != 42 {
+		t.Fatal("expected GetLastCommittedOffset to reflect the update")
+	}
+}
+
+func TestUpdateRegistryIgnoresEmptyIdentifier(t *testing.T) {
+	a, registry := newTestAuditor()
+
+	a.updateRegistry("", 42, "42")
+
+	var count int
+	registry.Range(func(identifier string, entry RegistryEntry) { count++ })
+	if count != 0 {
+		t.Fatalf("expected no entries to be tracked, got %d", count)
+	}
+}
+
+func TestCleanupRegistryEvictsExpiredEntries(t *testing.T) {
+	a, registry := newTestAuditor()
+	a.entryTTL = time.Minute
+
+	registry.Set("file:/tmp/fresh.log", RegistryEntry{LastUpdated: time.Now().UTC()})
+	registry.Set("file:/tmp/expired.log", RegistryEntry{LastUpdated: time.Now().UTC().Add(-time.Hour)})
+
+	a.cleanupRegistry()
+
+	if _, exists := registry.Get("file:/tmp/fresh.log"); !exists {
+		t.Fatal("expected fresh entry to be kept")
+	}
+	if _, exists := registry.Get("file:/tmp/expired.log"); exists {
+		t.Fatal("expected expired entry to be evicted")
+	}
+}
+
+func TestRunSkipsFlushWhenNothingChanged(t *testing.T) {
+	registry := &countingRegistry{MemoryRegistry: NewMemoryRegistry()}
+	a := New(make(chan message.Message), registry, WithFlushPeriod(10*time.Millisecond), WithCleanupPeriod(time.Hour))
+	a.Start()
+
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&registry.flushes) != 0 {
+		t.Fatalf("expected no flushes while the registry is unchanged, got %d", atomic.LoadInt32(&registry.flushes))
+	}
+
+	a.updateRegistry("file:/tmp/a.log", 1, "1")
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&registry.flushes) == 0 {
+		t.Fatal("expected a flush once an entry was updated")
+	}
+
+	a.Stop()
+}
+
+func TestFlushClearsDirtyState(t *testing.T) {
+	registry := &countingRegistry{MemoryRegistry: NewMemoryRegistry()}
+	a := New(make(chan message.Message), registry)
+
+	a.updateRegistry("file:/tmp/a.log", 1, "1")
+	if !a.needsFlush() {
+		t.Fatal("expected a pending flush after an update")
+	}
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if a.needsFlush() {
+		t.Fatal("expected no pending flush right after Flush")
+	}
+}
+
+func TestStatusReflectsRegistryState(t *testing.T) {
+	a, _ := newTestAuditor()
+
+	a.updateRegistry("file:/tmp/a.log", 1, "1")
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	status := a.Status()
+	if status.Entries != 1 {
+		t.Fatalf("expected 1 entry, got %d", status.Entries)
+	}
+	if status.LastFlushTime.IsZero() {
+		t.Fatal("expected LastFlushTime to be set after a flush")
+	}
+	if status.LastFlushErr != "" {
+		t.Fatalf("expected no flush error, got %q", status.LastFlushErr)
+	}
+}
+
+func TestGetLastCommittedOffsetUnknownIdentifier(t *testing.T) {
+	a, _ := newTestAuditor()
+	if a.GetLastCommittedOffset("file:/tmp/unknown.log") != 0 {
+		t.Fatal("expected 0 for an unknown identifier")
+	}
+	if a.GetLastCommittedTimestamp("file:/tmp/unknown.log") != "" {
+		t.Fatal("expected empty timestamp for an unknown identifier")
+	}
+}
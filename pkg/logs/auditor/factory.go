@@ -0,0 +1,40 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package auditor
+
+import "fmt"
+
+// Backend identifies which Registry implementation NewRegistry constructs,
+// selected through the logs_config.registry_backend setting.
+type Backend string
+
+const (
+	// BackendJSONFile persists the registry to a single registry.json file.
+	// This is the default, for backwards compatibility with existing agent
+	// installs.
+	BackendJSONFile Backend = "json"
+	// BackendBoltDB persists the registry to a registry.db BoltDB file,
+	// writing only changed entries on each flush.
+	BackendBoltDB Backend = "boltdb"
+	// BackendMemory keeps the registry in memory only; it does not survive
+	// a restart.
+	BackendMemory Backend = "memory"
+)
+
+// NewRegistry builds the Registry selected by backend, which should come
+// from the logs_config.registry_backend setting.
+func NewRegistry(runPath string, backend Backend) (Registry, error) {
+	switch backend {
+	case BackendJSONFile, "":
+		return NewJSONFileRegistry(runPath), nil
+	case BackendBoltDB:
+		return NewBoltRegistry(runPath)
+	case BackendMemory:
+		return NewMemoryRegistry(), nil
+	default:
+		return nil, fmt.Errorf("unknown logs_config.registry_backend %q", backend)
+	}
+}
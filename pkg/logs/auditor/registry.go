@@ -0,0 +1,26 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package auditor
+
+// Registry persists RegistryEntry state for tailed log sources so the
+// Auditor can resume from the last durably committed offset after a
+// restart. Implementations are responsible for their own internal
+// synchronization: every method must be safe for concurrent use.
+type Registry interface {
+	// Get returns the entry stored for identifier, and whether it exists.
+	Get(identifier string) (RegistryEntry, bool)
+	// Set stores entry for identifier, overwriting any existing value.
+	Set(identifier string, entry RegistryEntry)
+	// Delete removes the entry for identifier.
+	Delete(identifier string)
+	// Range calls f once for every identifier/entry pair currently in the
+	// registry. f must not call back into the Registry.
+	Range(f func(identifier string, entry RegistryEntry))
+	// Flush durably persists any pending changes.
+	Flush() error
+	// Close releases any resources held by the registry.
+	Close() error
+}
@@ -0,0 +1,180 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package auditor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	log "github.com/cihub/seelog"
+)
+
+// bucketV1 holds the current schema and is the only bucket in the database;
+// there is no v0 bucket. Legacy v0 and v1 state is imported from a
+// registry.json file instead (see migrateFromJSONFile). Future schema changes
+// should add a new bucketVN and a migration step in NewBoltRegistry instead of
+// mutating entries already written to bucketV1.
+var bucketV1 = []byte("registry_v1")
+
+// BoltRegistry is a Registry backed by a BoltDB file. Unlike JSONFileRegistry
+// it does not rewrite every entry on each Flush: only identifiers touched
+// since the last flush are written to (or removed from) the database, which
+// keeps flushes cheap when thousands of log sources churn identifiers.
+type BoltRegistry struct {
+	db *bolt.DB
+
+	mu      sync.Mutex
+	entries map[string]RegistryEntry
+	dirty   map[string]struct{}
+	deleted map[string]struct{}
+}
+
+// NewBoltRegistry opens (creating if needed) a BoltDB-backed registry at
+// runPath/registry.db. If the v1 bucket is empty, a legacy registry.json
+// found in runPath is migrated in, reusing the same v0/v1 unmarshaling logic
+// as JSONFileRegistry.
+func NewBoltRegistry(runPath string) (*BoltRegistry, error) {
+	db, err := bolt.Open(filepath.Join(runPath, "registry.db"), 0644, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &BoltRegistry{
+		db:      db,
+		entries: make(map[string]RegistryEntry),
+		dirty:   make(map[string]struct{}),
+		deleted: make(map[string]struct{}),
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketV1)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var entry RegistryEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			r.entries[string(k)] = entry
+			return nil
+		})
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if len(r.entries) == 0 {
+		if err := r.migrateFromJSONFile(runPath); err != nil && !os.IsNotExist(err) {
+			log.Warn(err)
+		}
+	}
+
+	return r, nil
+}
+
+// migrateFromJSONFile imports a legacy registry.json, if any is found in
+// runPath, writing every recovered entry into the v1 bucket once.
+func (r *BoltRegistry) migrateFromJSONFile(runPath string) error {
+	entries, err := recoverRegistryFile(filepath.Join(runPath, "registry.json"))
+	if err != nil {
+		return err
+	}
+	for identifier, entry := range entries {
+		r.entries[identifier] = entry
+		r.dirty[identifier] = struct{}{}
+	}
+	return r.Flush()
+}
+
+// Get implements Registry.
+func (r *BoltRegistry) Get(identifier string) (RegistryEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, exists := r.entries[identifier]
+	return entry, exists
+}
+
+// Set implements Registry.
+func (r *BoltRegistry) Set(identifier string, entry RegistryEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[identifier] = entry
+	r.dirty[identifier] = struct{}{}
+	delete(r.deleted, identifier)
+}
+
+// Delete implements Registry.
+func (r *BoltRegistry) Delete(identifier string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, identifier)
+	delete(r.dirty, identifier)
+	r.deleted[identifier] = struct{}{}
+}
+
+// Range implements Registry.
+func (r *BoltRegistry) Range(f func(identifier string, entry RegistryEntry)) {
+	r.mu.Lock()
+	entries := make(map[string]RegistryEntry, len(r.entries))
+	for identifier, entry := range r.entries {
+		entries[identifier] = entry
+	}
+	r.mu.Unlock()
+	for identifier, entry := range entries {
+		f(identifier, entry)
+	}
+}
+
+// Flush writes only the identifiers set or deleted since the last Flush.
+func (r *BoltRegistry) Flush() error {
+	r.mu.Lock()
+	dirty := r.dirty
+	deleted := r.deleted
+	entries := make(map[string]RegistryEntry, len(dirty))
+	for identifier := range dirty {
+		entries[identifier] = r.entries[identifier]
+	}
+	r.dirty = make(map[string]struct{})
+	r.deleted = make(map[string]struct{})
+	r.mu.Unlock()
+
+	if len(dirty) == 0 && len(deleted) == 0 {
+		return nil
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketV1)
+		for identifier := range dirty {
+			mr, err := json.Marshal(entries[identifier])
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(identifier), mr); err != nil {
+				return err
+			}
+		}
+		for identifier := range deleted {
+			if err := bucket.Delete([]byte(identifier)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close flushes any pending changes and closes the underlying database.
+func (r *BoltRegistry) Close() error {
+	if err := r.Flush(); err != nil {
+		r.db.Close()
+		return err
+	}
+	return r.db.Close()
+}
@@ -0,0 +1,68 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package auditor
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBoltRegistryPersistsAcrossRestarts(t *testing.T) {
+	runPath, err := ioutil.TempDir("", "auditor-bolt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(runPath)
+
+	r, err := NewBoltRegistry(runPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Set("file:/tmp/a.log", RegistryEntry{Offset: 42, Timestamp: "42"})
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewBoltRegistry(runPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	entry, exists := reopened.Get("file:/tmp/a.log")
+	if !exists || entry.Offset != 42 {
+		t.Fatalf("expected recovered offset 42, got %+v (exists=%v)", entry, exists)
+	}
+}
+
+func TestBoltRegistryMigratesLegacyJSONFile(t *testing.T) {
+	runPath, err := ioutil.TempDir("", "auditor-bolt-migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(runPath)
+
+	jsonRegistry := NewJSONFileRegistry(runPath)
+	jsonRegistry.Set("file:/tmp/legacy.log", RegistryEntry{Offset: 7, Timestamp: "7"})
+	if err := jsonRegistry.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewBoltRegistry(runPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	entry, exists := r.Get("file:/tmp/legacy.log")
+	if !exists || entry.Offset != 7 {
+		t.Fatalf("expected migrated offset 7, got %+v (exists=%v)", entry, exists)
+	}
+}
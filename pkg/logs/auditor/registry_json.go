@@ -0,0 +1,282 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package auditor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const defaultRegistryFileMode = os.FileMode(0644)
+
+// JSONFileRegistry is a Registry backed by a single JSON file on disk. It
+// rewrites the whole file on every Flush, which does not scale well when
+// thousands of identifiers churn, but it is kept for backwards compatibility
+// with existing registry.json files and for migrating the v0 schema; prefer
+// BoltRegistry for agents tracking a large number of log sources.
+type JSONFileRegistry struct {
+	path     string
+	fileMode os.FileMode
+	mu       sync.Mutex
+	entries  map[string]RegistryEntry
+}
+
+// JSONFileOption overrides a default setting of a JSONFileRegistry created
+// with NewJSONFileRegistry.
+type JSONFileOption func(*JSONFileRegistry)
+
+// WithRegistryFileMode makes the registry create registry.json with the
+// given permissions instead of the default 0644, for operators running
+// under a restricted umask.
+func WithRegistryFileMode(mode os.FileMode) JSONFileOption {
+	return func(r *JSONFileRegistry) {
+		r.fileMode = mode
+	}
+}
+
+// NewJSONFileRegistry returns a JSONFileRegistry rooted at runPath/registry.json,
+// recovering any state found there, or in its crash-recovery backup.
+func NewJSONFileRegistry(runPath string, opts ...JSONFileOption) *JSONFileRegistry {
+	r := &JSONFileRegistry{
+		path:     filepath.Join(runPath, "registry.json"),
+		fileMode: defaultRegistryFileMode,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.entries = r.recover()
+	return r
+}
+
+// Get implements Registry.
+func (r *JSONFileRegistry) Get(identifier string) (RegistryEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, exists := r.entries[identifier]
+	return entry, exists
+}
+
+// Set implements Registry.
+func (r *JSONFileRegistry) Set(identifier string, entry RegistryEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[identifier] = entry
+}
+
+// Delete implements Registry.
+func (r *JSONFileRegistry) Delete(identifier string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, identifier)
+}
+
+// Range implements Registry.
+func (r *JSONFileRegistry) Range(f func(identifier string, entry RegistryEntry)) {
+	r.mu.Lock()
+	entries := make(map[string]RegistryEntry, len(r.entries))
+	for identifier, entry := range r.entries {
+		entries[identifier] = entry
+	}
+	r.mu.Unlock()
+	for identifier, entry := range entries {
+		f(identifier, entry)
+	}
+}
+
+// Close flushes one last time; the JSON file needs no other teardown.
+func (r *JSONFileRegistry) Close() error {
+	return r.Flush()
+}
+
+// Flush writes the whole registry to disk.
+func (r *JSONFileRegistry) Flush() error {
+	r.mu.Lock()
+	entries := make(map[string]RegistryEntry, len(r.entries))
+	for identifier, entry := range r.entries {
+		entries[identifier] = entry
+	}
+	r.mu.Unlock()
+
+	mr, err := marshalRegistryEntries(entries)
+	if err != nil {
+		return err
+	}
+	return r.writeFile(mr)
+}
+
+// oldPath returns the path of the backup left behind by the previous
+// successful flush, used by recover if the primary file is corrupted.
+func (r *JSONFileRegistry) oldPath() string {
+	return r.path + ".old"
+}
+
+// writeFile durably persists mr to r.path. It writes to a sibling ".new"
+// file and fsyncs it, renames the current primary file to ".old" so it can
+// be used as a fallback by recover, renames ".new" over the primary, and
+// finally fsyncs the containing directory so the rename itself survives a
+// crash. This mirrors the write-temp-fsync-rename pattern used by
+// filebeat's registrar to avoid truncating registry.json on a crash or
+// power loss mid-write.
+func (r *JSONFileRegistry) writeFile(mr []byte) error {
+	dir := filepath.Dir(r.path)
+	newPath := r.path + ".new"
+
+	f, err := os.OpenFile(newPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, r.fileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(mr); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(r.path); err == nil {
+		if err := os.Rename(r.path, r.oldPath()); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(newPath, r.path); err != nil {
+		return err
+	}
+
+	return fsyncDirectory(dir)
+}
+
+// fsyncDirectory fsyncs dir so that the directory entry created by a prior
+// rename is durable, not just the file it points to.
+func fsyncDirectory(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// recover rebuilds the registry from the state file found at path, falling
+// back to the previous flush's backup if the primary file is missing or
+// corrupted.
+func (r *JSONFileRegistry) recover() map[string]RegistryEntry {
+	entries, err := recoverRegistryFile(r.path)
+	if err == nil {
+		return entries
+	}
+	if os.IsNotExist(err) {
+		// writeFile leaves no primary file between its two renames, so a crash
+		// there is recovered from the backup; with no backup either, this is a
+		// first start.
+		entries, err = recoverRegistryFile(r.oldPath())
+		if err == nil {
+			return entries
+		}
+		if !os.IsNotExist(err) {
+			log.Error(err)
+			recoverErrors.Add(1)
+		}
+		return make(map[string]RegistryEntry)
+	}
+	log.Error(err)
+	recoverErrors.Add(1)
+
+	log.Warnf("could not recover registry from %s, falling back to %s", r.path, r.oldPath())
+	entries, err = recoverRegistryFile(r.oldPath())
+	if err != nil {
+		log.Error(err)
+		recoverErrors.Add(1)
+		return make(map[string]RegistryEntry)
+	}
+	return entries
+}
+
+// recoverRegistryFile reads and unmarshals the registry stored at path.
+func recoverRegistryFile(path string) (map[string]RegistryEntry, error) {
+	mr, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalRegistryEntries(mr)
+}
+
+// jsonRegistry represents the registry as written on disk.
+type jsonRegistry struct {
+	Version  int
+	Registry map[string]RegistryEntry
+}
+
+// marshalRegistryEntries marshals a registry.
+func marshalRegistryEntries(entries map[string]RegistryEntry) ([]byte, error) {
+	r := jsonRegistry{
+		Version:  1,
+		Registry: entries,
+	}
+	return json.Marshal(r)
+}
+
+// unmarshalRegistryEntries unmarshals a registry, transparently migrating
+// the legacy v0 schema if needed.
+func unmarshalRegistryEntries(b []byte) (map[string]RegistryEntry, error) {
+	var r jsonRegistry
+	err := json.Unmarshal(b, &r)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]RegistryEntry)
+	if r.Version == 1 {
+		for identifier, entry := range r.Registry {
+			entries[identifier] = entry
+		}
+	} else if r.Version == 0 {
+		return unmarshalRegistryEntriesV0(b)
+	}
+	return entries, nil
+}
+
+// Legacy registry logic
+
+type registryEntryV0 struct {
+	Path      string
+	Timestamp time.Time
+	Offset    int64
+}
+
+type jsonRegistryV0 struct {
+	Version  int
+	Registry map[string]registryEntryV0
+}
+
+func unmarshalRegistryEntriesV0(b []byte) (map[string]RegistryEntry, error) {
+	var r jsonRegistryV0
+	err := json.Unmarshal(b, &r)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]RegistryEntry)
+	for path, entry := range r.Registry {
+		newEntry := RegistryEntry{}
+		newEntry.Offset = entry.Offset
+		newEntry.LastUpdated = entry.Timestamp
+		newEntry.Timestamp = ""
+		// from v0 to v1, we also prefixed path with file:
+		newPath := fmt.Sprintf("file:%s", path)
+		entries[newPath] = newEntry
+	}
+	return entries, nil
+}
@@ -0,0 +1,110 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package auditor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestJSONFileRegistry(t *testing.T) (*JSONFileRegistry, string) {
+	runPath, err := ioutil.TempDir("", "auditor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewJSONFileRegistry(runPath), runPath
+}
+
+func TestJSONFileRegistryFlushWritesAtomically(t *testing.T) {
+	r, runPath := newTestJSONFileRegistry(t)
+	defer os.RemoveAll(runPath)
+
+	r.Set("file:/tmp/a.log", RegistryEntry{Offset: 42, Timestamp: "42"})
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(r.path); err != nil {
+		t.Fatalf("expected registry file to exist: %v", err)
+	}
+	if _, err := os.Stat(r.path + ".new"); !os.IsNotExist(err) {
+		t.Fatalf("expected .new file to be renamed away, got err %v", err)
+	}
+
+	recovered := NewJSONFileRegistry(runPath)
+	entry, exists := recovered.Get("file:/tmp/a.log")
+	if !exists || entry.Offset != 42 {
+		t.Fatalf("expected recovered offset 42, got %+v (exists=%v)", entry, exists)
+	}
+}
+
+func TestJSONFileRegistryRecoverFallsBackToOldOnCorruption(t *testing.T) {
+	r, runPath := newTestJSONFileRegistry(t)
+	defer os.RemoveAll(runPath)
+
+	r.Set("file:/tmp/a.log", RegistryEntry{Offset: 1, Timestamp: "1"})
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Set("file:/tmp/a.log", RegistryEntry{Offset: 2, Timestamp: "2"})
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncate the primary file to simulate a crash mid-write.
+	if err := ioutil.WriteFile(r.path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered := NewJSONFileRegistry(runPath)
+	entry, exists := recovered.Get("file:/tmp/a.log")
+	if !exists {
+		t.Fatal("expected registry to be recovered from the .old backup")
+	}
+	if entry.Offset != 1 {
+		t.Fatalf("expected offset from .old backup (1), got %d", entry.Offset)
+	}
+}
+
+func TestJSONFileRegistryRecoverMissingFileReturnsEmptyRegistry(t *testing.T) {
+	before := recoverErrors.Value()
+
+	r, runPath := newTestJSONFileRegistry(t)
+	defer os.RemoveAll(runPath)
+
+	var count int
+	r.Range(func(identifier string, entry RegistryEntry) { count++ })
+	if count != 0 {
+		t.Fatalf("expected empty registry, got %d entries", count)
+	}
+	if after := recoverErrors.Value(); after != before {
+		t.Fatalf("expected no recover error to be counted for a missing registry.json, got %d -> %d", before, after)
+	}
+}
+
+func TestWithRegistryFileMode(t *testing.T) {
+	runPath, err := ioutil.TempDir("", "auditor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(runPath)
+
+	r := NewJSONFileRegistry(runPath, WithRegistryFileMode(0600))
+	r.Set("file:/tmp/a.log", RegistryEntry{Offset: 1})
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(runPath, "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,68 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package auditor
+
+import "sync"
+
+// MemoryRegistry is a Registry that keeps entries in memory only. It never
+// touches disk, which makes it a good fit for unit tests and for ephemeral
+// agents that don't need offsets to survive a restart.
+type MemoryRegistry struct {
+	mu      sync.Mutex
+	entries map[string]RegistryEntry
+}
+
+// NewMemoryRegistry returns a new, empty MemoryRegistry.
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		entries: make(map[string]RegistryEntry),
+	}
+}
+
+// Get implements Registry.
+func (r *MemoryRegistry) Get(identifier string) (RegistryEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, exists := r.entries[identifier]
+	return entry, exists
+}
+
+// Set implements Registry.
+func (r *MemoryRegistry) Set(identifier string, entry RegistryEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[identifier] = entry
+}
+
+// Delete implements Registry.
+func (r *MemoryRegistry) Delete(identifier string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, identifier)
+}
+
+// Range implements Registry.
+func (r *MemoryRegistry) Range(f func(identifier string, entry RegistryEntry)) {
+	r.mu.Lock()
+	entries := make(map[string]RegistryEntry, len(r.entries))
+	for identifier, entry := range r.entries {
+		entries[identifier] = entry
+	}
+	r.mu.Unlock()
+	for identifier, entry := range entries {
+		f(identifier, entry)
+	}
+}
+
+// Flush is a no-op: MemoryRegistry has nothing to persist.
+func (r *MemoryRegistry) Flush() error {
+	return nil
+}
+
+// Close is a no-op.
+func (r *MemoryRegistry) Close() error {
+	return nil
+}
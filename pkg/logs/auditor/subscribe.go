@@ -0,0 +1,173 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package auditor
+
+import "sync"
+
+// commitNotificationBufferSize bounds how many pending notifications a
+// subscriber can fall behind on. Once full, the oldest pending notification
+// is dropped in favor of the newest: subscribers only care about the most
+// recently committed state, not every commit in between.
+const commitNotificationBufferSize = 1
+
+// A CommitEvent is delivered to SubscribeAll subscribers once per Flush,
+// carrying every identifier durably committed by that flush. Batching a
+// whole flush into one event, rather than one event per identifier, keeps
+// commitNotificationBufferSize's drop-oldest backpressure from silently
+// discarding some identifiers of a flush in favor of others.
+type CommitEvent struct {
+	Entries map[string]RegistryEntry
+}
+
+type subscriberID uint64
+
+// subscribers tracks Subscribe/SubscribeAll channels and notifies them once
+// a flush durably commits their identifier. It is a separate type from
+// Auditor so its own mutex never has to be held while sending on a
+// subscriber's channel could block run.
+type subscribers struct {
+	mu           sync.Mutex
+	nextID       subscriberID
+	byIdentifier map[string]map[subscriberID]chan RegistryEntry
+	all          map[subscriberID]chan CommitEvent
+}
+
+func newSubscribers() *subscribers {
+	return &subscribers{
+		byIdentifier: make(map[string]map[subscriberID]chan RegistryEntry),
+		all:          make(map[subscriberID]chan CommitEvent),
+	}
+}
+
+func (s *subscribers) addIdentifier(identifier string, ch chan RegistryEntry) subscriberID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	id := s.nextID
+	if s.byIdentifier[identifier] == nil {
+		s.byIdentifier[identifier] = make(map[subscriberID]chan RegistryEntry)
+	}
+	s.byIdentifier[identifier][id] = ch
+	return id
+}
+
+func (s *subscribers) removeIdentifier(identifier string, id subscriberID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if subs, ok := s.byIdentifier[identifier]; ok {
+		delete(subs, id)
+		if len(subs) == 0 {
+			delete(s.byIdentifier, identifier)
+		}
+	}
+}
+
+func (s *subscribers) addAll(ch chan CommitEvent) subscriberID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	id := s.nextID
+	s.all[id] = ch
+	return id
+}
+
+func (s *subscribers) removeAll(id subscriberID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.all, id)
+}
+
+// notify delivers entry to every subscriber of identifier. The subscriber
+// map is copied out under the lock so that sending on a slow subscriber's
+// channel can never block a concurrent Subscribe/unsubscribe call.
+func (s *subscribers) notify(identifier string, entry RegistryEntry) {
+	s.mu.Lock()
+	identifierChans := make([]chan RegistryEntry, 0, len(s.byIdentifier[identifier]))
+	for _, ch := range s.byIdentifier[identifier] {
+		identifierChans = append(identifierChans, ch)
+	}
+	s.mu.Unlock()
+
+	for _, ch := range identifierChans {
+		sendEntryDroppingOldest(ch, entry)
+	}
+}
+
+// notifyAll delivers a single CommitEvent bundling every entry committed by
+// one Flush to every SubscribeAll subscriber. The subscriber map is copied
+// out under the lock for the same reason as notify.
+func (s *subscribers) notifyAll(entries map[string]RegistryEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	allChans := make([]chan CommitEvent, 0, len(s.all))
+	for _, ch := range s.all {
+		allChans = append(allChans, ch)
+	}
+	s.mu.Unlock()
+
+	event := CommitEvent{Entries: entries}
+	for _, ch := range allChans {
+		sendEventDroppingOldest(ch, event)
+	}
+}
+
+// sendEntryDroppingOldest sends entry on ch, dropping the oldest buffered
+// value first if ch is full.
+func sendEntryDroppingOldest(ch chan RegistryEntry, entry RegistryEntry) {
+	for {
+		select {
+		case ch <- entry:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// sendEventDroppingOldest sends event on ch, dropping the oldest buffered
+// value first if ch is full.
+func sendEventDroppingOldest(ch chan CommitEvent, event CommitEvent) {
+	for {
+		select {
+		case ch <- event:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel that receives the RegistryEntry for identifier
+// every time it is durably committed to the registry, i.e. right after a
+// successful Flush, not on every in-memory update. The channel is buffered;
+// if the subscriber falls behind, the oldest pending notification is
+// dropped in favor of the newest one. The returned cancel function is safe
+// to call concurrently, safe to call more than once, and never blocks run.
+func (a *Auditor) Subscribe(identifier string) (<-chan RegistryEntry, func()) {
+	ch := make(chan RegistryEntry, commitNotificationBufferSize)
+	id := a.subs.addIdentifier(identifier, ch)
+	var once sync.Once
+	return ch, func() { once.Do(func() { a.subs.removeIdentifier(identifier, id) }) }
+}
+
+// SubscribeAll returns a channel that receives one CommitEvent per Flush,
+// bundling every identifier that flush durably committed, with the same
+// buffering and drop-oldest backpressure semantics as Subscribe.
+func (a *Auditor) SubscribeAll() (<-chan CommitEvent, func()) {
+	ch := make(chan CommitEvent, commitNotificationBufferSize)
+	id := a.subs.addAll(ch)
+	var once sync.Once
+	return ch, func() { once.Do(func() { a.subs.removeAll(id) }) }
+}
@@ -0,0 +1,118 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package auditor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+)
+
+func TestSubscribeNotifiedOnlyAfterFlush(t *testing.T) {
+	a, _ := newTestAuditor()
+	ch, cancel := a.Subscribe("file:/tmp/a.log")
+	defer cancel()
+
+	a.updateRegistry("file:/tmp/a.log", 1, "1")
+
+	select {
+	case <-ch:
+		t.Fatal("expected no notification before a flush has succeeded")
+	default:
+	}
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case entry := <-ch:
+		if entry.Offset != 1 {
+			t.Fatalf("expected offset 1, got %d", entry.Offset)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a notification after a successful flush")
+	}
+}
+
+func TestSubscribeAllReceivesEveryIdentifier(t *testing.T) {
+	a, _ := newTestAuditor()
+	ch, cancel := a.SubscribeAll()
+	defer cancel()
+
+	a.updateRegistry("file:/tmp/a.log", 1, "1")
+	a.updateRegistry("file:/tmp/b.log", 2, "2")
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-ch:
+		if len(event.Entries) != 2 {
+			t.Fatalf("expected both identifiers batched into one commit event, got %v", event.Entries)
+		}
+		if _, ok := event.Entries["file:/tmp/a.log"]; !ok {
+			t.Fatalf("expected file:/tmp/a.log in commit event, got %v", event.Entries)
+		}
+		if _, ok := event.Entries["file:/tmp/b.log"]; !ok {
+			t.Fatalf("expected file:/tmp/b.log in commit event, got %v", event.Entries)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a commit event")
+	}
+}
+
+func TestSubscribeDropsOldestOnBackpressure(t *testing.T) {
+	a, _ := newTestAuditor()
+	ch, cancel := a.Subscribe("file:/tmp/a.log")
+	defer cancel()
+
+	for i := int64(1); i <= 3; i++ {
+		a.updateRegistry("file:/tmp/a.log", i, "x")
+		if err := a.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case entry := <-ch:
+		if entry.Offset != 3 {
+			t.Fatalf("expected the most recent offset 3 to survive backpressure, got %d", entry.Offset)
+		}
+	default:
+		t.Fatal("expected a buffered notification")
+	}
+
+	select {
+	case <-ch:
+		t.Fatal("expected only the latest notification to be buffered")
+	default:
+	}
+}
+
+func TestUnsubscribeIsSafeConcurrentlyAndDuringStop(t *testing.T) {
+	a := New(make(chan message.Message), NewMemoryRegistry())
+	_, cancelOne := a.Subscribe("file:/tmp/a.log")
+	_, cancelAll := a.SubscribeAll()
+	a.Start()
+
+	done := make(chan struct{})
+	go func() {
+		cancelOne()
+		close(done)
+	}()
+	cancelOne()
+	<-done
+
+	stopped := make(chan struct{})
+	go func() {
+		a.Stop()
+		close(stopped)
+	}()
+	cancelAll()
+	<-stopped
+}
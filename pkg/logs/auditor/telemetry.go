@@ -0,0 +1,58 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package auditor
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Telemetry for the auditor, published under the "logs_auditor" expvar map
+// so it shows up alongside the rest of the agent's runtime stats.
+var (
+	auditorExpvars = expvar.NewMap("logs_auditor")
+
+	registryEntries  = new(expvar.Int)
+	flushErrorsTotal = new(expvar.Int)
+	entriesEvicted   = new(expvar.Int)
+	recoverErrors    = new(expvar.Int)
+	flushDuration    = new(durationHistogram)
+)
+
+func init() {
+	auditorExpvars.Set("registry_entries", registryEntries)
+	auditorExpvars.Set("flush_duration_seconds", flushDuration)
+	auditorExpvars.Set("flush_errors_total", flushErrorsTotal)
+	auditorExpvars.Set("entries_evicted_total", entriesEvicted)
+	auditorExpvars.Set("recover_errors_total", recoverErrors)
+}
+
+// durationHistogram is a minimal expvar.Var tracking the count, sum and most
+// recent value observed, which is enough to derive an average flush latency
+// without pulling in a full metrics library.
+type durationHistogram struct {
+	mu    sync.Mutex
+	count int64
+	sum   float64
+	last  float64
+}
+
+func (h *durationHistogram) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.count++
+	h.sum += d.Seconds()
+	h.last = d.Seconds()
+}
+
+// String implements expvar.Var.
+func (h *durationHistogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return fmt.Sprintf(`{"count":%d,"sum":%f,"last":%f}`, h.count, h.sum, h.last)
+}